@@ -0,0 +1,139 @@
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/flimzy/kivik/errors"
+	"github.com/monoculum/formam"
+)
+
+// authRequest is a normalized /_session request: exactly one of Token,
+// IDToken, Code, or RefreshToken is set, identifying which grant the
+// client is presenting.
+type authRequest struct {
+	Provider string
+
+	Token        string // access_token
+	IDToken      string // id_token
+	Code         string // code (+ RedirectURI, + optional CodeVerifier)
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string // refresh_token
+}
+
+// rawAuthRequest mirrors the wire schema of a /_session request body,
+// before validating that exactly one grant was supplied.
+type rawAuthRequest struct {
+	Provider     *string `json:"provider" formam:"provider"`
+	Token        *string `json:"access_token" formam:"access_token"`
+	IDToken      *string `json:"id_token" formam:"id_token"`
+	Code         *string `json:"code" formam:"code"`
+	RedirectURI  *string `json:"redirect_uri" formam:"redirect_uri"`
+	CodeVerifier *string `json:"code_verifier" formam:"code_verifier"`
+	RefreshToken *string `json:"refresh_token" formam:"refresh_token"`
+}
+
+func present(s *string) bool { return s != nil && *s != "" }
+
+// newAuthRequest validates raw and normalizes it into an authRequest. If
+// raw carries no recognized auth fields at all, both return values are
+// nil, signaling the caller to let the standard auth handler try instead.
+func newAuthRequest(raw *rawAuthRequest) (*authRequest, error) {
+	grants := 0
+	for _, g := range []*string{raw.Token, raw.IDToken, raw.Code, raw.RefreshToken} {
+		if present(g) {
+			grants++
+		}
+	}
+	if raw.Provider == nil && grants == 0 {
+		// Do nothing, let the standard auth handler try
+		return nil, nil
+	}
+	if raw.Provider == nil {
+		return nil, errors.Status(http.StatusBadRequest, "No provider specified")
+	}
+	if grants == 0 {
+		return nil, errors.Status(http.StatusBadRequest, "No access token provided")
+	}
+	if grants > 1 {
+		return nil, errors.Status(http.StatusBadRequest, "Multiple auth grants specified; send exactly one of access_token, id_token, code, or refresh_token")
+	}
+
+	req := &authRequest{Provider: *raw.Provider}
+	switch {
+	case present(raw.Token):
+		req.Token = *raw.Token
+	case present(raw.IDToken):
+		req.IDToken = *raw.IDToken
+	case present(raw.Code):
+		req.Code = *raw.Code
+		if present(raw.RedirectURI) {
+			req.RedirectURI = *raw.RedirectURI
+		}
+		if present(raw.CodeVerifier) {
+			req.CodeVerifier = *raw.CodeVerifier
+		}
+	case present(raw.RefreshToken):
+		req.RefreshToken = *raw.RefreshToken
+	}
+	return req, nil
+}
+
+// parseAuthRequest will parse the request body for an auth request, returning
+// an error if it was unable to do so. If there is no OAuth2 auth request, the
+// request body is restored (by replacing the io.Reader with another that will
+// return the same bytes), and nil is returned.
+func parseAuthRequest(r *http.Request) (*authRequest, error) {
+	var parser func([]byte) (*rawAuthRequest, error)
+	switch ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct {
+	case "application/json":
+		parser = parseJSONAuthRequest
+	case "application/x-www-form-urlencoded":
+		parser = parseFormAuthRequest
+	default:
+		return nil, nil
+	}
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil, errors.Status(http.StatusBadRequest, "missing body")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := parser(body)
+	if err != nil {
+		return nil, err
+	}
+	authReq, err := newAuthRequest(raw)
+	if authReq == nil && err == nil {
+		// Restore the body for pass-through
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return authReq, err
+}
+
+func parseFormAuthRequest(body []byte) (*rawAuthRequest, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, errors.WrapStatus(http.StatusBadRequest, err)
+	}
+	var raw rawAuthRequest
+	if e := formam.NewDecoder(&formam.DecoderOptions{IgnoreUnknownKeys: true}).Decode(values, &raw); e != nil {
+		return nil, errors.WrapStatus(http.StatusBadRequest, e)
+	}
+	return &raw, nil
+}
+
+func parseJSONAuthRequest(body []byte) (*rawAuthRequest, error) {
+	var raw rawAuthRequest
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, errors.WrapStatus(http.StatusBadRequest, err)
+	}
+	return &raw, nil
+}
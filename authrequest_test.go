@@ -0,0 +1,381 @@
+package oauth2
+
+import (
+	"bytes"
+	stderrors "errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik/errors"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestNewAuthRequest(t *testing.T) {
+	type narTest struct {
+		Name     string
+		Raw      *rawAuthRequest
+		Expected *authRequest
+		Error    string
+	}
+	tests := []narTest{
+		{
+			Name: "Nothing",
+			Raw:  &rawAuthRequest{},
+		},
+		{
+			Name:  "NoProvider",
+			Raw:   &rawAuthRequest{Token: strPtr("bar")},
+			Error: "No provider specified",
+		},
+		{
+			Name:  "NoGrant",
+			Raw:   &rawAuthRequest{Provider: strPtr("bar")},
+			Error: "No access token provided",
+		},
+		{
+			Name:  "MultipleGrants",
+			Raw:   &rawAuthRequest{Provider: strPtr("bar"), Token: strPtr("foo"), IDToken: strPtr("baz")},
+			Error: "Multiple auth grants specified; send exactly one of access_token, id_token, code, or refresh_token",
+		},
+		{
+			Name:     "AccessToken",
+			Raw:      &rawAuthRequest{Provider: strPtr("bar"), Token: strPtr("foo")},
+			Expected: &authRequest{Provider: "bar", Token: "foo"},
+		},
+		{
+			Name:     "IDToken",
+			Raw:      &rawAuthRequest{Provider: strPtr("bar"), IDToken: strPtr("foo.bar.baz")},
+			Expected: &authRequest{Provider: "bar", IDToken: "foo.bar.baz"},
+		},
+		{
+			Name: "Code",
+			Raw: &rawAuthRequest{
+				Provider:     strPtr("bar"),
+				Code:         strPtr("authcode"),
+				RedirectURI:  strPtr("https://app.example.com/cb"),
+				CodeVerifier: strPtr("verifier"),
+			},
+			Expected: &authRequest{
+				Provider:     "bar",
+				Code:         "authcode",
+				RedirectURI:  "https://app.example.com/cb",
+				CodeVerifier: "verifier",
+			},
+		},
+		{
+			Name:     "RefreshToken",
+			Raw:      &rawAuthRequest{Provider: strPtr("bar"), RefreshToken: strPtr("reftok")},
+			Expected: &authRequest{Provider: "bar", RefreshToken: "reftok"},
+		},
+	}
+	for _, test := range tests {
+		func(test narTest) {
+			t.Run(test.Name, func(t *testing.T) {
+				result, err := newAuthRequest(test.Raw)
+				var msg string
+				if err != nil {
+					msg = err.Error()
+				}
+				if msg != test.Error {
+					t.Errorf("Unexpected error: %s", msg)
+				}
+				if d := diff.Interface(test.Expected, result); d != nil {
+					t.Error(d)
+				}
+			})
+		}(test)
+	}
+}
+
+type errorReader struct{}
+
+var _ io.Reader = &errorReader{}
+
+func (r *errorReader) Read(_ []byte) (int, error) {
+	return 0, stderrors.New("errorReader")
+}
+
+func TestParseAuthRequest(t *testing.T) {
+	type arTest struct {
+		Name     string
+		Request  *http.Request
+		Expected *authRequest
+		Error    string
+		Status   int
+		Remain   string
+	}
+	tests := []arTest{
+		{
+			Name: "BadReader",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", &errorReader{})
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			}(),
+			Error:  "errorReader",
+			Status: http.StatusInternalServerError,
+		},
+		{
+			Name: "MissingFormBody",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", nil)
+				_ = req.Body.Close()
+				req.Body = nil
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			}(),
+			Error:  "missing body",
+			Status: http.StatusBadRequest,
+		},
+		{
+			Name: "ZeroLengthBody",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(""))
+				_ = req.Body.Close()
+				req.Body = nil
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			}(),
+			Error:  "missing body",
+			Status: http.StatusBadRequest,
+		},
+		{
+			Name:    "NoMediaType",
+			Request: httptest.NewRequest(http.MethodPost, "/_session", nil),
+			Status:  0, // No error; falls through
+		},
+		{
+			Name: "OtherMediaType",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", &errorReader{})
+				req.Header.Set("Content-Type", "image/jpeg")
+				return req
+			}(),
+			Status: 0, // No error; falls through
+		},
+		{
+			Name: "InvalidJSON",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader("{invalid!!"))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			}(),
+			Error:  "invalid character 'i' looking for beginning of object key string",
+			Status: http.StatusBadRequest,
+		},
+		{
+			Name: "NonOAuth2JSON",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(`{"foo":"bar"}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			}(),
+			Remain: `{"foo":"bar"}`,
+		},
+		{
+			Name: "ValidJSON",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(`{"provider":"foo","access_token":"bar"}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			}(),
+			Expected: &authRequest{Provider: "foo", Token: "bar"},
+		},
+		{
+			Name: "ValidJSONIDToken",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(`{"provider":"foo","id_token":"bar.baz.qux"}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			}(),
+			Expected: &authRequest{Provider: "foo", IDToken: "bar.baz.qux"},
+		},
+		{
+			Name: "InvalidForm",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader("invalid%xx"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			}(),
+			Error:  `invalid URL escape "%xx"`,
+			Status: http.StatusBadRequest,
+		},
+		{
+			Name: "NonOAuth2Form",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader("foo=bar&bar=baz"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			}(),
+			Remain: "foo=bar&bar=baz",
+		},
+		{
+			Name: "ValidForm",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader("provider=foo&access_token=bar"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			}(),
+			Expected: &authRequest{Provider: "foo", Token: "bar"},
+		},
+	}
+	for _, test := range tests {
+		func(test arTest) {
+			t.Run(test.Name, func(t *testing.T) {
+				result, err := parseAuthRequest(test.Request)
+				var msg string
+				if err != nil {
+					msg = err.Error()
+				}
+				if msg != test.Error {
+					t.Errorf("Unexpected error: %s", msg)
+				}
+				if status := errors.StatusCode(err); status != test.Status {
+					t.Errorf("Unexpected error status: %d", status)
+				}
+				if err != nil {
+					return
+				}
+				if d := diff.Interface(test.Expected, result); d != nil {
+					t.Error(d)
+				}
+				remain := &bytes.Buffer{}
+				_, _ = remain.ReadFrom(test.Request.Body)
+				_ = test.Request.Body.Close()
+				if remain.String() != test.Remain {
+					t.Errorf("Remaining body\nExpected: %s\n  Actual: %s", test.Remain, remain)
+				}
+			})
+		}(test)
+	}
+}
+
+func TestParseFormAuthRequest(t *testing.T) {
+	type formTest struct {
+		Name     string
+		Request  *http.Request
+		Error    string
+		Status   int
+		Expected *authRequest
+	}
+	tests := []formTest{
+		{
+			Name: "InvalidForm",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(`invalid%xxx`))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			}(),
+			Error:  `invalid URL escape "%xx"`,
+			Status: http.StatusBadRequest,
+		},
+		{
+			Name: "ValidForm",
+			Request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(`provider=foo&access_token=bar`))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			}(),
+			Expected: &authRequest{Provider: "foo", Token: "bar"},
+		},
+	}
+	for _, test := range tests {
+		func(test formTest) {
+			t.Run(test.Name, func(t *testing.T) {
+				body, err := ioutil.ReadAll(test.Request.Body)
+				_ = test.Request.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				raw, err := parseFormAuthRequest(body)
+				var msg string
+				if err != nil {
+					msg = err.Error()
+				}
+				if msg != test.Error {
+					t.Errorf("Unexpected error: %s", msg)
+				}
+				if status := errors.StatusCode(err); status != test.Status {
+					t.Errorf("Unexpected error status: %d", status)
+				}
+				if err != nil {
+					return
+				}
+				result, err := newAuthRequest(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d := diff.Interface(test.Expected, result); d != nil {
+					t.Error(d)
+				}
+			})
+		}(test)
+	}
+}
+
+func TestParseJSONAuthRequest(t *testing.T) {
+	type jsonTest struct {
+		Name     string
+		Request  *http.Request
+		Error    string
+		Status   int
+		Expected *authRequest
+		Remain   string
+	}
+	tests := []jsonTest{
+		{
+			Name:    "InvalidJSON",
+			Request: httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(`{invalid!!`)),
+			Status:  http.StatusBadRequest,
+			Error:   "invalid character 'i' looking for beginning of object key string",
+		},
+		{
+			Name:    "Passthrough",
+			Request: httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(`{"foo":"bar"}`)),
+			Remain:  `{"foo":"bar"}`,
+		},
+		{
+			Name:     "ValidJSON",
+			Request:  httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(`{"provider":"foo","access_token":"bar"}`)),
+			Expected: &authRequest{Provider: "foo", Token: "bar"},
+		},
+	}
+	for _, test := range tests {
+		func(test jsonTest) {
+			t.Run(test.Name, func(t *testing.T) {
+				body, err := ioutil.ReadAll(test.Request.Body)
+				_ = test.Request.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				raw, err := parseJSONAuthRequest(body)
+				var msg string
+				if err != nil {
+					msg = err.Error()
+				}
+				if msg != test.Error {
+					t.Errorf("Unexpected error: %s", msg)
+				}
+				if status := errors.StatusCode(err); status != test.Status {
+					t.Errorf("Unexpected error status: %d", status)
+				}
+				if err != nil {
+					return
+				}
+				result, err := newAuthRequest(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d := diff.Interface(test.Expected, result); d != nil {
+					t.Error(d)
+				}
+			})
+		}(test)
+	}
+}
@@ -0,0 +1,64 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flimzy/kivik/errors"
+)
+
+// CodeExchangeProvider is a Provider that can exchange an authorization
+// code, or a provider-issued refresh token, for an access token on the
+// client's behalf — for clients that drove the redirect to the provider
+// themselves (unlike /_oauth/{provider}/login + /_oauth/{provider}/callback,
+// which this package drives end-to-end) and simply hand the result to
+// /_session.
+type CodeExchangeProvider interface {
+	Provider
+	TokenURL() string
+	ClientID() string
+	ClientSecret() string
+}
+
+// exchangeToken posts a token grant to provider's token endpoint and
+// returns the resulting access token. grant supplies the grant-specific
+// form fields (e.g. "code"/"redirect_uri"/"code_verifier" for an
+// authorization_code grant, or "refresh_token" for a refresh_token grant).
+func exchangeToken(ctx context.Context, client *http.Client, provider CodeExchangeProvider, grantType string, grant url.Values) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", grantType)
+	form.Set("client_id", provider.ClientID())
+	form.Set("client_secret", provider.ClientSecret())
+	for k, v := range grant {
+		form[k] = v
+	}
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenURL(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.WrapStatus(http.StatusBadGateway, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", errors.WrapStatus(http.StatusBadGateway, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return "", errors.Statusf(http.StatusBadGateway, "token exchange failed: %s", result.Error)
+	}
+	return result.AccessToken, nil
+}
@@ -0,0 +1,69 @@
+package oauth2
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// allowedOrigin reports whether origin may access /_session, returning the
+// value to send as Access-Control-Allow-Origin. An entry of "*" in
+// m.cors.allowedOrigins allows any origin, but is never echoed when
+// AllowCredentials is set, per the CORS spec's prohibition on combining a
+// wildcard origin with credentialed requests. Origins are matched exactly;
+// an origin absent from the allowlist is never echoed back.
+func (m *mux) allowedOrigin(origin string) (string, bool) {
+	if origin == "" {
+		return "", false
+	}
+	var wildcard bool
+	for _, allowed := range m.cors.allowedOrigins {
+		if allowed == "*" {
+			wildcard = true
+			continue
+		}
+		if allowed == origin {
+			return origin, true
+		}
+	}
+	if wildcard {
+		if m.cors.allowCredentials {
+			return origin, true
+		}
+		return "*", true
+	}
+	return "", false
+}
+
+// writeCORSHeaders adds Access-Control-Allow-Origin (and, for credentialed
+// configurations, Access-Control-Allow-Credentials) to a POST or DELETE
+// /_session response, if the request's Origin is allowlisted.
+func (m *mux) writeCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	allow, ok := m.allowedOrigin(r.Header.Get("Origin"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allow)
+	w.Header().Add("Vary", "Origin")
+	if m.cors.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight handles OPTIONS /_session, responding with the CORS headers a
+// browser needs to follow up with the actual POST or DELETE request.
+func (m *mux) preflight(w http.ResponseWriter, r *http.Request) {
+	allow, ok := m.allowedOrigin(r.Header.Get("Origin"))
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allow)
+	w.Header().Add("Vary", "Origin")
+	if m.cors.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(m.cors.maxAge.Seconds())))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,115 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestOAuth2Preflight(t *testing.T) {
+	type preflightTest struct {
+		name             string
+		allowedOrigins   []string
+		allowCredentials bool
+		origin           string
+		wantOrigin       string
+		wantCredentials  bool
+	}
+	tests := []preflightTest{
+		{
+			name:           "NoOriginHeader",
+			allowedOrigins: []string{"https://app.example.com"},
+		},
+		{
+			name:           "DisallowedOrigin",
+			allowedOrigins: []string{"https://app.example.com"},
+			origin:         "https://evil.com",
+		},
+		{
+			name:           "AllowedOrigin",
+			allowedOrigins: []string{"https://app.example.com"},
+			origin:         "https://app.example.com",
+			wantOrigin:     "https://app.example.com",
+		},
+		{
+			name:           "WildcardWithoutCredentials",
+			allowedOrigins: []string{"*"},
+			origin:         "https://anywhere.example.com",
+			wantOrigin:     "*",
+		},
+		{
+			name:             "WildcardWithCredentialsEchoesOrigin",
+			allowedOrigins:   []string{"*"},
+			allowCredentials: true,
+			origin:           "https://anywhere.example.com",
+			wantOrigin:       "https://anywhere.example.com",
+			wantCredentials:  true,
+		},
+	}
+	for _, test := range tests {
+		func(test preflightTest) {
+			t.Run(test.name, func(t *testing.T) {
+				mw := OAuth2(map[string]Provider{}, Options{
+					Secret:           "foo",
+					AllowedOrigins:   test.allowedOrigins,
+					AllowCredentials: test.allowCredentials,
+				})
+				req := httptest.NewRequest(http.MethodOptions, "/_session", nil)
+				if test.origin != "" {
+					req.Header.Set("Origin", test.origin)
+				}
+				w := httptest.NewRecorder()
+				mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusNotFound)
+				})).ServeHTTP(w, req)
+				res := w.Result()
+				if res.StatusCode != http.StatusNoContent {
+					t.Fatalf("unexpected status: %d", res.StatusCode)
+				}
+				if got := res.Header.Get("Access-Control-Allow-Origin"); got != test.wantOrigin {
+					t.Errorf("Access-Control-Allow-Origin: got %q, want %q", got, test.wantOrigin)
+				}
+				if test.wantOrigin == "" {
+					return
+				}
+				if got := res.Header.Get("Access-Control-Allow-Methods"); got != "POST, DELETE, OPTIONS" {
+					t.Errorf("unexpected Access-Control-Allow-Methods: %q", got)
+				}
+				if got := res.Header.Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization" {
+					t.Errorf("unexpected Access-Control-Allow-Headers: %q", got)
+				}
+				if res.Header.Get("Access-Control-Max-Age") == "" {
+					t.Error("expected Access-Control-Max-Age to be set")
+				}
+				gotCredentials := res.Header.Get("Access-Control-Allow-Credentials") == "true"
+				if gotCredentials != test.wantCredentials {
+					t.Errorf("Access-Control-Allow-Credentials: got %v, want %v", gotCredentials, test.wantCredentials)
+				}
+			})
+		}(test)
+	}
+}
+
+func TestOAuth2PostCORSHeaders(t *testing.T) {
+	provider := &fakeOAuth2Provider{token: "tok", user: &fb.User{Name: "bob", Salt: "somesalt"}}
+	mw := OAuth2(map[string]Provider{"testprovider": provider}, Options{
+		Secret:         "foo",
+		AllowedOrigins: []string{"https://app.example.com"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/_session", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+	res := w.Result()
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin: got %q", got)
+	}
+	if got := res.Header.Get("Vary"); got != "Origin" {
+		t.Errorf("Vary: got %q", got)
+	}
+}
@@ -0,0 +1,32 @@
+package oauth2
+
+import (
+	"context"
+	"time"
+
+	"github.com/flimzy/kivik/authdb"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// CouchDBTokenIssuer is the default TokenIssuer. It mints a CouchDB auth
+// token, for clients relying on CouchDB's built-in cookie-based sessions.
+type CouchDBTokenIssuer struct {
+	// Secret is the CouchDB `couch_httpd_auth/secret` used to sign tokens.
+	Secret string
+	// TTL is how long issued tokens are valid. Defaults to 10 minutes.
+	TTL time.Duration
+}
+
+var _ TokenIssuer = &CouchDBTokenIssuer{}
+
+// Issue implements TokenIssuer.
+func (i *CouchDBTokenIssuer) Issue(_ context.Context, user *fb.User) (string, time.Time, error) {
+	ttl := i.TTL
+	if ttl == 0 {
+		ttl = defaultSessionTTL
+	}
+	now := time.Now().UTC()
+	token := authdb.CreateAuthToken(user.Name, user.Salt, i.Secret, now.Unix())
+	return token, now.Add(ttl), nil
+}
@@ -0,0 +1,218 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/flimzy/kivik/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// IDTokenProvider is a Provider that can verify an OIDC ID token directly,
+// for clients that completed the OIDC flow themselves and simply want to
+// exchange the resulting id_token for a CouchDB session.
+type IDTokenProvider interface {
+	Provider
+
+	// VerifyIDToken validates rawJWT — checking its signature against the
+	// issuer's published keys, and its iss, aud, exp, and nonce claims —
+	// and returns the corresponding user. Implementations will typically
+	// build this on top of JWKSCache and VerifyIDToken.
+	VerifyIDToken(ctx context.Context, rawJWT string) (*fb.User, error)
+}
+
+const defaultJWKSMaxAge = 5 * time.Minute
+
+type jwksCacheEntry struct {
+	keys    map[string]*rsa.PublicKey
+	expires time.Time
+}
+
+// JWKSCache fetches and caches a provider's signing keys, keyed by OIDC
+// issuer, honoring the Cache-Control: max-age of the jwks_uri response so
+// ID token verification doesn't hit the network on every login.
+type JWKSCache struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	entries map[string]jwksCacheEntry
+}
+
+// NewJWKSCache returns an empty JWKSCache that fetches keys using client.
+// If client is nil, http.DefaultClient is used.
+func NewJWKSCache(client *http.Client) *JWKSCache {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &JWKSCache{client: client, entries: map[string]jwksCacheEntry{}}
+}
+
+// Key returns the RSA public key with the given kid, published by issuer
+// (discovered via its OIDC discovery document), fetching and caching the
+// key set as needed.
+func (c *JWKSCache) Key(ctx context.Context, issuer, kid string) (*rsa.PublicKey, error) {
+	keys, err := c.keys(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errors.Statusf(http.StatusUnauthorized, "unknown signing key `%s` for issuer `%s`", kid, issuer)
+	}
+	return key, nil
+}
+
+func (c *JWKSCache) keys(ctx context.Context, issuer string) (map[string]*rsa.PublicKey, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[issuer]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.keys, nil
+	}
+
+	jwksURI, err := c.discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	keys, ttl, err := c.fetch(ctx, jwksURI)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[issuer] = jwksCacheEntry{keys: keys, expires: time.Now().Add(ttl)}
+	c.mu.Unlock()
+	return keys, nil
+}
+
+type oidcDiscoveryDoc struct {
+	JWKSURI string `json:"jwks_uri"`
+}
+
+func (c *JWKSCache) discover(ctx context.Context, issuer string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", errors.WrapStatus(http.StatusBadGateway, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var doc oidcDiscoveryDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", errors.WrapStatus(http.StatusBadGateway, err)
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.Statusf(http.StatusBadGateway, "issuer `%s` did not publish a jwks_uri", issuer)
+	}
+	return doc.JWKSURI, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	e := 0
+	for _, b := range eb {
+		e = e<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: e}, nil
+}
+
+func (c *JWKSCache) fetch(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, errors.WrapStatus(http.StatusBadGateway, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, 0, errors.WrapStatus(http.StatusBadGateway, err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		if pub, err := k.rsaPublicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, jwksMaxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+// jwksMaxAge parses the max-age directive of a Cache-Control header,
+// falling back to defaultJWKSMaxAge if absent or invalid.
+func jwksMaxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		if secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return defaultJWKSMaxAge
+}
+
+type idTokenClaims struct {
+	jwtgo.StandardClaims
+	Nonce string `json:"nonce"`
+}
+
+// VerifyIDToken validates rawJWT against issuer's published keys (via
+// cache) and checks the iss, aud, and exp claims, plus nonce when
+// expectedNonce is non-empty. IDTokenProvider implementations can build
+// VerifyIDToken on top of this.
+func VerifyIDToken(ctx context.Context, cache *JWKSCache, issuer, audience, expectedNonce, rawJWT string) (*fb.User, error) {
+	var claims idTokenClaims
+	_, err := jwtgo.ParseWithClaims(rawJWT, &claims, func(t *jwtgo.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return cache.Key(ctx, issuer, kid)
+	})
+	if err != nil {
+		return nil, errors.WrapStatus(http.StatusUnauthorized, err)
+	}
+	if claims.Issuer != issuer {
+		return nil, errors.Statusf(http.StatusUnauthorized, "unexpected issuer `%s`", claims.Issuer)
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return nil, errors.Status(http.StatusUnauthorized, "unexpected audience")
+	}
+	if expectedNonce != "" && claims.Nonce != expectedNonce {
+		return nil, errors.Status(http.StatusUnauthorized, "nonce mismatch")
+	}
+	return &fb.User{Name: claims.Subject}, nil
+}
@@ -0,0 +1,110 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/flimzy/kivik/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+type ctxKey int
+
+const ctxKeyUser ctxKey = iota
+
+// UserFromContext returns the user populated by JWTAuth, mirroring what the
+// CouchDB cookie path makes available once kivik's session middleware has
+// validated the cookie.
+func UserFromContext(ctx context.Context) (*fb.User, bool) {
+	user, ok := ctx.Value(ctxKeyUser).(*fb.User)
+	return user, ok
+}
+
+// jwtClaims are the standard claims issued by JWTTokenIssuer: sub, iat, exp,
+// plus the user's roles.
+type jwtClaims struct {
+	jwt.StandardClaims
+	Roles []string `json:"roles"`
+}
+
+// JWTTokenIssuer issues session tokens as signed JWTs, for SPA and mobile
+// clients that use `Authorization: Bearer` instead of cookies. It
+// implements TokenIssuer, and its tokens are also returned in the
+// /_session response body under "token".
+type JWTTokenIssuer struct {
+	// Method is the signing algorithm, e.g. jwt.SigningMethodHS256 or
+	// jwt.SigningMethodRS256. Defaults to HS256.
+	Method jwt.SigningMethod
+	// Key is the signing key: a []byte for HMAC methods, or a
+	// *rsa.PrivateKey for RSA methods.
+	Key interface{}
+	// TTL is how long issued tokens are valid. Defaults to 10 minutes.
+	TTL time.Duration
+}
+
+var _ TokenIssuer = &JWTTokenIssuer{}
+
+// Issue implements TokenIssuer.
+func (i *JWTTokenIssuer) Issue(_ context.Context, user *fb.User) (string, time.Time, error) {
+	method := i.Method
+	if method == nil {
+		method = jwt.SigningMethodHS256
+	}
+	ttl := i.TTL
+	if ttl == 0 {
+		ttl = defaultSessionTTL
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	claims := jwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.Name,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+		Roles: user.Roles,
+	}
+	signed, err := jwt.NewWithClaims(method, claims).SignedString(i.Key)
+	if err != nil {
+		return "", time.Time{}, errors.WrapStatus(http.StatusInternalServerError, err)
+	}
+	return signed, expiresAt, nil
+}
+
+// JWTAuth is middleware that verifies an `Authorization: Bearer` JWT minted
+// by JWTTokenIssuer, and makes the authenticated user available via
+// UserFromContext to downstream handlers. Requests without a bearer token
+// are passed through unmodified, so JWTAuth may be chained alongside other
+// authentication schemes.
+func JWTAuth(method jwt.SigningMethod, key interface{}) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const prefix = "Bearer "
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var claims jwtClaims
+			_, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, prefix), &claims, func(t *jwt.Token) (interface{}, error) {
+				if t.Method != method {
+					return nil, errors.Status(http.StatusUnauthorized, "unexpected signing method")
+				}
+				return key, nil
+			})
+			if err != nil {
+				reportError(w, errors.WrapStatus(http.StatusUnauthorized, err))
+				return
+			}
+			ctx := context.WithValue(r.Context(), ctxKeyUser, &fb.User{
+				Name:  claims.Subject,
+				Roles: claims.Roles,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
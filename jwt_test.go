@@ -0,0 +1,64 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestJWTIssuerAndAuth(t *testing.T) {
+	issuer := &JWTTokenIssuer{Method: jwt.SigningMethodHS256, Key: []byte("sssh")}
+	user := &fb.User{Name: "alice", Roles: []string{"admin"}}
+	token, expiresAt, err := issuer.Issue(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresAt.Before(time.Now()) {
+		t.Error("expiresAt should be in the future")
+	}
+
+	var seen *fb.User
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = UserFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	type jwtAuthTest struct {
+		name   string
+		header string
+		status int
+		user   bool
+	}
+	mw := JWTAuth(issuer.Method, issuer.Key)
+	tests := []jwtAuthTest{
+		{name: "NoAuth", status: http.StatusOK},
+		{name: "ValidToken", header: "Bearer " + token, status: http.StatusOK, user: true},
+		{name: "GarbageToken", header: "Bearer not-a-jwt", status: http.StatusUnauthorized},
+		{name: "WrongScheme", header: "Basic " + token, status: http.StatusOK},
+	}
+	for _, test := range tests {
+		func(test jwtAuthTest) {
+			t.Run(test.name, func(t *testing.T) {
+				seen = nil
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if test.header != "" {
+					req.Header.Set("Authorization", test.header)
+				}
+				w := httptest.NewRecorder()
+				mw(next).ServeHTTP(w, req)
+				if w.Result().StatusCode != test.status {
+					t.Errorf("unexpected status: %d", w.Result().StatusCode)
+				}
+				if test.user && (seen == nil || seen.Name != "alice") {
+					t.Errorf("expected user alice in context, got %+v", seen)
+				}
+			})
+		}(test)
+	}
+}
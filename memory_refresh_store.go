@@ -0,0 +1,78 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/flimzy/kivik/errors"
+)
+
+// MemoryRefreshStore is an in-memory RefreshStore, suitable for tests and
+// single-process deployments. Tokens do not survive a restart.
+type MemoryRefreshStore struct {
+	mu     sync.Mutex
+	tokens map[string]*RefreshToken
+}
+
+var _ RefreshStore = &MemoryRefreshStore{}
+
+// NewMemoryRefreshStore returns an empty MemoryRefreshStore.
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{tokens: map[string]*RefreshToken{}}
+}
+
+// Save implements RefreshStore.
+func (s *MemoryRefreshStore) Save(_ context.Context, token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *token
+	s.tokens[token.Hash] = &cp
+	return nil
+}
+
+// Rotate implements RefreshStore.
+func (s *MemoryRefreshStore) Rotate(_ context.Context, hash, replacedBy string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[hash]
+	if !ok {
+		return nil, errors.Status(http.StatusUnauthorized, "unknown refresh token")
+	}
+	if token.Used {
+		s.revokeAllLocked(token.UserID)
+		return nil, errors.Status(http.StatusUnauthorized, "refresh token reuse detected")
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, errors.Status(http.StatusUnauthorized, "refresh token expired")
+	}
+	cp := *token
+	token.Used = true
+	token.ReplacedBy = replacedBy
+	return &cp, nil
+}
+
+// Revoke implements RefreshStore.
+func (s *MemoryRefreshStore) Revoke(_ context.Context, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, hash)
+	return nil
+}
+
+// RevokeAllForUser implements RefreshStore.
+func (s *MemoryRefreshStore) RevokeAllForUser(_ context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeAllLocked(userID)
+	return nil
+}
+
+func (s *MemoryRefreshStore) revokeAllLocked(userID string) {
+	for hash, token := range s.tokens {
+		if token.UserID == userID {
+			delete(s.tokens, hash)
+		}
+	}
+}
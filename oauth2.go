@@ -1,24 +1,15 @@
 package oauth2
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"mime"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
 	"github.com/flimzy/kivik"
-	"github.com/flimzy/kivik/authdb"
 	"github.com/flimzy/kivik/errors"
 	"github.com/flimzy/log"
-	"github.com/monoculum/formam"
-
-	fb "github.com/FlashbackSRS/flashback-model"
 )
 
 func reportError(w http.ResponseWriter, err error) {
@@ -37,150 +28,127 @@ func reportError(w http.ResponseWriter, err error) {
 	}
 }
 
-// Provider is a copy of flashback-server2/providers.Provider
-type Provider interface {
-	GetUser(ctx context.Context, token string) (*fb.User, error)
+// mux holds the state shared by the OAuth2 middleware's endpoints: the
+// access-token exchange at /_session, and the server-side Authorization
+// Code + PKCE flow at /_oauth/{provider}/login and /_oauth/{provider}/callback.
+type mux struct {
+	providers         map[string]Provider
+	secret            string
+	client            *http.Client
+	issuer            TokenIssuer
+	cookie            cookieOptions
+	refreshStore      RefreshStore
+	refreshTTL        time.Duration
+	cors              corsOptions
+	redirectValidator RedirectValidator
 }
 
-// OAuth2 is middleware for OAuth2 authentication.
-func OAuth2(providers map[string]Provider, secret string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodPost || r.URL.Path != "/_session" {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			authReq, err := parseAuthRequest(r)
-			if err != nil {
-				reportError(w, err)
-				return
-			}
-			if authReq == nil {
-				next.ServeHTTP(w, r)
-				return
-			}
-			var user *fb.User
-			if provider, ok := providers[authReq.Provider]; ok {
-				user, err = provider.GetUser(r.Context(), authReq.Token)
-				if err != nil {
-					reportError(w, err)
-					return
-				}
-			} else {
-				reportError(w, errors.Statusf(http.StatusBadRequest, "unknown auth provider `%s`", authReq.Provider))
-				return
-			}
-			token := authdb.CreateAuthToken(user.Name, user.Salt, secret, time.Now().UTC().Unix())
-			w.Header().Set("Cache-Control", "must-revalidate")
-			w.Header().Add("Content-Type", "application/json")
-			http.SetCookie(w, &http.Cookie{
-				Name:     kivik.SessionCookieName,
-				Value:    token,
-				Path:     "/",
-				MaxAge:   10 * 60, // 10 min, TODO: configure this
-				HttpOnly: true,
-			})
-			if redir := r.URL.Query().Get("next"); redir != "" {
-				if !strings.HasPrefix(redir, "/") {
-					// Only relative redirections are permitted
-					reportError(w, errors.Status(http.StatusBadRequest, "prohibited redirection"))
-				}
-				w.Header().Add("Location", redir)
-				w.WriteHeader(kivik.StatusFound)
-			} else {
-				w.WriteHeader(kivik.StatusOK)
-			}
-			err = json.NewEncoder(w).Encode(map[string]interface{}{
-				"ok":    true,
-				"name":  user.Name,
-				"roles": user.Roles,
-			})
-			if err != nil {
-				reportError(w, err)
-			}
-		})
-	}
+type cookieOptions struct {
+	name     string
+	path     string
+	secure   bool
+	sameSite http.SameSite
 }
 
-type authRequest struct {
-	Provider string `json:"provider"`
-	Token    string `json:"access_token"`
+type corsOptions struct {
+	allowedOrigins   []string
+	allowCredentials bool
+	maxAge           time.Duration
 }
 
-func newAuthRequest(provider, token *string) (*authRequest, error) {
-	if provider == nil && token == nil {
-		// Do nothing, let the standard auth handler try
-		return nil, nil
+// OAuth2 is middleware for OAuth2 authentication.
+func OAuth2(providers map[string]Provider, opts Options) func(http.Handler) http.Handler {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
 	}
-	if provider == nil {
-		return nil, errors.Status(http.StatusBadRequest, "No provider specified")
+	issuer := opts.TokenIssuer
+	if issuer == nil {
+		issuer = &CouchDBTokenIssuer{Secret: opts.Secret, TTL: opts.SessionTTL}
 	}
-	if token == nil {
-		return nil, errors.Status(http.StatusBadRequest, "No access token provided")
+	name := opts.CookieName
+	if name == "" {
+		name = kivik.SessionCookieName
 	}
-	return &authRequest{
-		Provider: *provider,
-		Token:    *token,
-	}, nil
-}
-
-// parseAuthRequest will parse the request body for an auth request, returning
-// an error if it was unable to do so. If there is no OAuth2 auth request, the
-// request body is restored (by replacing the io.Reader with another that will
-// return the same bytes), and nil is returned.
-func parseAuthRequest(r *http.Request) (*authRequest, error) {
-	var parser func([]byte) (*string, *string, error)
-	switch ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct {
-	case "application/json":
-		parser = parseJSONAuthRequest
-	case "application/x-www-form-urlencoded":
-		parser = parseFormAuthRequest
-	default:
-		return nil, nil
+	path := opts.CookiePath
+	if path == "" {
+		path = "/"
 	}
-	if r.Body == nil || r.ContentLength == 0 {
-		return nil, errors.Status(http.StatusBadRequest, "missing body")
+	refreshTTL := opts.RefreshTTL
+	if refreshTTL == 0 {
+		refreshTTL = defaultRefreshTTL
 	}
-	body, err := ioutil.ReadAll(r.Body)
-	_ = r.Body.Close()
-	if err != nil {
-		return nil, err
+	maxAge := opts.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultCORSMaxAge
 	}
-	provider, token, err := parser(body)
-	if err != nil {
-		return nil, err
+	redirectValidator := opts.RedirectValidator
+	if redirectValidator == nil {
+		redirectValidator = defaultRedirectValidator(opts.AllowedRedirectHosts)
 	}
-	authReq, err := newAuthRequest(provider, token)
-	if authReq == nil && err == nil {
-		// Restore the body for pass-through
-		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	m := &mux{
+		providers: providers,
+		secret:    opts.Secret,
+		client:    client,
+		issuer:    issuer,
+		cookie: cookieOptions{
+			name:     name,
+			path:     path,
+			secure:   opts.Secure,
+			sameSite: opts.SameSite,
+		},
+		refreshStore: opts.RefreshStore,
+		refreshTTL:   refreshTTL,
+		cors: corsOptions{
+			allowedOrigins:   opts.AllowedOrigins,
+			allowCredentials: opts.AllowCredentials,
+			maxAge:           maxAge,
+		},
+		redirectValidator: redirectValidator,
 	}
-	return authReq, err
+	return m.middleware
 }
 
-func parseFormAuthRequest(body []byte) (*string, *string, error) {
-	values, err := url.ParseQuery(string(body))
-	if err != nil {
-		return nil, nil, errors.WrapStatus(http.StatusBadRequest, err)
-	}
-	var authReq struct {
-		Provider *string `formam:"provider"`
-		Token    *string `formam:"access_token"`
-	}
-	if e := formam.NewDecoder(&formam.DecoderOptions{IgnoreUnknownKeys: true}).Decode(values, &authReq); e != nil {
-		return nil, nil, errors.WrapStatus(http.StatusBadRequest, e)
-	}
-	return authReq.Provider, authReq.Token, nil
+func (m *mux) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodOptions && r.URL.Path == "/_session":
+			m.preflight(w, r)
+		case r.Method == http.MethodPost && r.URL.Path == "/_session":
+			m.writeCORSHeaders(w, r)
+			m.session(w, r, next)
+		case r.Method == http.MethodPost && r.URL.Path == "/_session/refresh":
+			m.refresh(w, r)
+		case r.Method == http.MethodDelete && r.URL.Path == "/_session":
+			m.writeCORSHeaders(w, r)
+			m.logout(w, r)
+		default:
+			if name, action, ok := parseOAuthPath(r.URL.Path); ok {
+				m.oauth(w, r, name, action)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	})
 }
 
-func parseJSONAuthRequest(body []byte) (*string, *string, error) {
-	var authReq struct {
-		Provider *string `json:"provider"`
-		Token    *string `json:"access_token"`
+// oauth dispatches a /_oauth/{provider}/{action} request to the named
+// provider, which must implement OAuth2Provider.
+func (m *mux) oauth(w http.ResponseWriter, r *http.Request, name, action string) {
+	provider, ok := m.providers[name]
+	if !ok {
+		reportError(w, errors.Statusf(http.StatusBadRequest, "unknown auth provider `%s`", name))
+		return
+	}
+	oauthProvider, ok := provider.(OAuth2Provider)
+	if !ok {
+		reportError(w, errors.Statusf(http.StatusBadRequest, "provider `%s` does not support the OAuth2 authorization code flow", name))
+		return
 	}
-	if err := json.Unmarshal(body, &authReq); err != nil {
-		return nil, nil, errors.WrapStatus(http.StatusBadRequest, err)
+	switch action {
+	case "login":
+		m.login(w, r, name, oauthProvider)
+	case "callback":
+		m.callback(w, r, name, oauthProvider)
 	}
-	return authReq.Provider, authReq.Token, nil
 }
@@ -0,0 +1,86 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// defaultSessionTTL is used when Options.SessionTTL is unset.
+const defaultSessionTTL = 10 * time.Minute
+
+// defaultCORSMaxAge is used when Options.MaxAge is unset.
+const defaultCORSMaxAge = 10 * time.Minute
+
+// TokenIssuer mints the value stored in the session cookie (and, for
+// bearer-token clients, returned in the response body) for a freshly
+// authenticated user.
+type TokenIssuer interface {
+	// Issue returns the session token value and its expiry.
+	Issue(ctx context.Context, user *fb.User) (value string, expiresAt time.Time, err error)
+}
+
+// Options configures the OAuth2 middleware.
+type Options struct {
+	// Secret signs the CouchDB auth cookie minted by the default
+	// TokenIssuer, and the PKCE state cookie used by the Authorization Code
+	// flow. Required.
+	Secret string
+
+	// Client is used for server-side token exchange requests against a
+	// provider's token endpoint. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// TokenIssuer mints session tokens. Defaults to a CouchDBTokenIssuer
+	// built from Secret and SessionTTL.
+	TokenIssuer TokenIssuer
+
+	// SessionTTL is how long a freshly issued session is valid, when using
+	// the default CouchDBTokenIssuer. Defaults to 10 minutes. Ignored when
+	// TokenIssuer is set explicitly; set the TTL there instead.
+	SessionTTL time.Duration
+
+	// CookieName is the name of the session cookie. Defaults to
+	// kivik.SessionCookieName.
+	CookieName string
+	// CookiePath is the path scope of the session cookie. Defaults to "/".
+	CookiePath string
+	// Secure marks the session cookie as HTTPS-only.
+	Secure bool
+	// SameSite sets the SameSite attribute of the session cookie.
+	SameSite http.SameSite
+
+	// RefreshStore, if set, turns on long-lived refresh tokens: one is
+	// issued alongside every session, POST /_session/refresh becomes
+	// available, and DELETE /_session also revokes the presented refresh
+	// token. Leave nil to disable refresh tokens entirely; DELETE /_session
+	// still clears the session cookie.
+	RefreshStore RefreshStore
+	// RefreshTTL is how long an issued refresh token is valid. Defaults to
+	// 30 days.
+	RefreshTTL time.Duration
+
+	// AllowedOrigins enables CORS on /_session for the listed origins.
+	// An entry of "*" allows any origin. Origins are matched exactly
+	// (never as a substring); an Origin that isn't allowlisted is never
+	// echoed back, even when AllowCredentials is set. Leave nil to
+	// disable CORS entirely.
+	AllowedOrigins []string
+	// AllowCredentials sets Access-Control-Allow-Credentials: true, and
+	// requires echoing the exact matched origin rather than "*".
+	AllowCredentials bool
+	// MaxAge sets how long a browser may cache a preflight response, via
+	// Access-Control-Max-Age. Defaults to 10 minutes.
+	MaxAge time.Duration
+
+	// RedirectValidator guards the ?next= redirect honored after a
+	// successful session exchange. Defaults to a validator that accepts
+	// only same-origin relative paths, widened by AllowedRedirectHosts.
+	RedirectValidator RedirectValidator
+	// AllowedRedirectHosts lets the default RedirectValidator also accept
+	// absolute next URLs whose host is in this list. Ignored when
+	// RedirectValidator is set explicitly.
+	AllowedRedirectHosts []string
+}
@@ -0,0 +1,72 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func doLogin(t *testing.T, mw func(http.Handler) http.Handler) *http.Response {
+	t.Helper()
+	body := `{"provider":"testprovider","access_token":"tok"}`
+	req := httptest.NewRequest(http.MethodPost, "/_session", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+	return w.Result()
+}
+
+func TestOAuth2CookieDefaults(t *testing.T) {
+	provider := &fakeOAuth2Provider{token: "tok", user: &fb.User{Name: "bob", Salt: "somesalt"}}
+	mw := OAuth2(map[string]Provider{"testprovider": provider}, Options{Secret: "foo"})
+	res := doLogin(t, mw)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", res.StatusCode)
+	}
+	cookies := res.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != kivik.SessionCookieName || cookies[0].Path != "/" {
+		t.Errorf("unexpected cookies: %v", cookies)
+	}
+}
+
+func TestOAuth2CustomCookie(t *testing.T) {
+	provider := &fakeOAuth2Provider{token: "tok", user: &fb.User{Name: "bob", Salt: "somesalt"}}
+	mw := OAuth2(map[string]Provider{"testprovider": provider}, Options{
+		Secret:     "foo",
+		CookieName: "custom_session",
+		CookiePath: "/app",
+		Secure:     true,
+	})
+	res := doLogin(t, mw)
+	cookies := res.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "custom_session" || cookies[0].Path != "/app" || !cookies[0].Secure {
+		t.Errorf("unexpected cookies: %v", cookies)
+	}
+}
+
+func TestOAuth2JWTIssuerIncludesTokenInBody(t *testing.T) {
+	provider := &fakeOAuth2Provider{token: "tok", user: &fb.User{Name: "bob", Salt: "somesalt"}}
+	mw := OAuth2(map[string]Provider{"testprovider": provider}, Options{
+		Secret:      "foo",
+		TokenIssuer: &JWTTokenIssuer{Key: []byte("sssh")},
+	})
+	res := doLogin(t, mw)
+	defer func() { _ = res.Body.Close() }()
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Token == "" {
+		t.Error("expected a JWT in the response body")
+	}
+}
@@ -0,0 +1,230 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flimzy/kivik/errors"
+)
+
+const (
+	// oauthStateCookieName is the signed, short-lived cookie that carries
+	// the PKCE code_verifier and CSRF state nonce between the /login and
+	// /callback legs of the Authorization Code flow.
+	oauthStateCookieName = "_oauth2_state"
+	oauthStateCookiePath = "/_oauth/"
+	oauthStateCookieTTL  = 10 * time.Minute
+)
+
+// oauthState is the payload carried in the state cookie.
+type oauthState struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	CodeVerifier string `json:"code_verifier"`
+	Next         string `json:"next,omitempty"`
+}
+
+// parseOAuthPath extracts the provider name and action ("login" or
+// "callback") from a request path of the form /_oauth/{provider}/{action}.
+func parseOAuthPath(path string) (provider, action string, ok bool) {
+	const prefix = "/_oauth/"
+	if !strings.HasPrefix(path, prefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	if parts[1] != "login" && parts[1] != "callback" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// randomString returns a URL-safe base64 encoding of n cryptographically
+// random bytes.
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// codeChallenge computes the S256 PKCE code challenge for verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// redirectURI reconstructs the callback URL that must be registered with
+// the provider for name.
+func redirectURI(r *http.Request, name string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/_oauth/%s/callback", scheme, r.Host, name)
+}
+
+// login begins the Authorization Code + PKCE flow: it generates a
+// code_verifier and CSRF state, stashes them in a signed cookie, and
+// redirects to the provider's authorization endpoint.
+func (m *mux) login(w http.ResponseWriter, r *http.Request, name string, provider OAuth2Provider) {
+	verifier, err := randomString(64) // 64 random bytes -> 86 base64url chars, within the 43-128 spec range
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	state, err := randomString(16)
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	cookie, err := m.signState(oauthState{
+		Provider:     name,
+		State:        state,
+		CodeVerifier: verifier,
+		Next:         r.URL.Query().Get("next"),
+	})
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	http.SetCookie(w, cookie)
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", provider.ClientID())
+	q.Set("redirect_uri", redirectURI(r, name))
+	q.Set("state", state)
+	q.Set("code_challenge", codeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	if scopes := provider.Scopes(); len(scopes) > 0 {
+		q.Set("scope", strings.Join(scopes, " "))
+	}
+
+	http.Redirect(w, r, provider.AuthURL()+"?"+q.Encode(), http.StatusFound)
+}
+
+// callback validates the state cookie and query param, exchanges the
+// authorization code for an access token, and issues a session exactly as
+// the /_session access-token exchange does.
+func (m *mux) callback(w http.ResponseWriter, r *http.Request, name string, provider OAuth2Provider) {
+	cookie, err := r.Cookie(oauthStateCookieName)
+	if err != nil {
+		reportError(w, errors.Status(http.StatusBadRequest, "missing oauth state cookie"))
+		return
+	}
+	http.SetCookie(w, expiredCookie(oauthStateCookieName))
+
+	st, err := m.verifyState(cookie.Value)
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	if st.Provider != name {
+		reportError(w, errors.Status(http.StatusBadRequest, "oauth state does not match provider"))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(st.State), []byte(r.URL.Query().Get("state"))) != 1 {
+		reportError(w, errors.Status(http.StatusBadRequest, "oauth state mismatch"))
+		return
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		reportError(w, errors.Status(http.StatusBadRequest, "missing authorization code"))
+		return
+	}
+
+	token, err := m.exchangeCode(r.Context(), provider, code, st.CodeVerifier, redirectURI(r, name))
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	user, err := provider.GetUser(r.Context(), token)
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+
+	if st.Next != "" {
+		q := r.URL.Query()
+		q.Set("next", st.Next)
+		r.URL.RawQuery = q.Encode()
+	}
+	m.issueSession(w, r, user, name)
+}
+
+// exchangeCode posts the authorization code grant to the provider's token
+// endpoint and returns the resulting access token.
+func (m *mux) exchangeCode(ctx context.Context, provider OAuth2Provider, code, verifier, redirectURL string) (string, error) {
+	grant := url.Values{
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {verifier},
+	}
+	return exchangeToken(ctx, m.client, provider, "authorization_code", grant)
+}
+
+// signState HMAC-signs st with the middleware secret and wraps it in the
+// short-lived state cookie.
+func (m *mux) signState(st oauthState) (*http.Cookie, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(m.secret))
+	mac.Write([]byte(encoded))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    encoded + "." + sig,
+		Path:     oauthStateCookiePath,
+		MaxAge:   int(oauthStateCookieTTL.Seconds()),
+		HttpOnly: true,
+	}, nil
+}
+
+// verifyState checks the cookie's signature and unmarshals the state.
+func (m *mux) verifyState(value string) (*oauthState, error) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.Status(http.StatusBadRequest, "malformed oauth state")
+	}
+	mac := hmac.New(sha256.New, []byte(m.secret))
+	mac.Write([]byte(parts[0]))
+	expected := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(parts[1])) != 1 {
+		return nil, errors.Status(http.StatusBadRequest, "invalid oauth state signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.WrapStatus(http.StatusBadRequest, err)
+	}
+	var st oauthState
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return nil, errors.WrapStatus(http.StatusBadRequest, err)
+	}
+	return &st, nil
+}
+
+func expiredCookie(name string) *http.Cookie {
+	return &http.Cookie{
+		Name:   name,
+		Path:   oauthStateCookiePath,
+		Value:  "",
+		MaxAge: -1,
+	}
+}
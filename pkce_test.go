@@ -0,0 +1,202 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/flimzy/kivik"
+	"github.com/flimzy/kivik/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+type fakeOAuth2Provider struct {
+	authURL, tokenURL, clientID, clientSecret string
+	scopes                                    []string
+	token                                     string
+	user                                      *fb.User
+}
+
+func (p *fakeOAuth2Provider) AuthURL() string      { return p.authURL }
+func (p *fakeOAuth2Provider) TokenURL() string     { return p.tokenURL }
+func (p *fakeOAuth2Provider) ClientID() string     { return p.clientID }
+func (p *fakeOAuth2Provider) ClientSecret() string { return p.clientSecret }
+func (p *fakeOAuth2Provider) Scopes() []string     { return p.scopes }
+
+func (p *fakeOAuth2Provider) GetUser(_ context.Context, token string) (*fb.User, error) {
+	if token != p.token {
+		return nil, errors.Status(http.StatusUnauthorized, "invalid token")
+	}
+	return p.user, nil
+}
+
+// newTestMux builds a mux with sane defaults for tests that exercise the
+// PKCE login/callback handlers directly.
+func newTestMux(providers map[string]Provider, secret string) *mux {
+	return &mux{
+		providers: providers,
+		secret:    secret,
+		client:    http.DefaultClient,
+		issuer:    &CouchDBTokenIssuer{Secret: secret},
+		cookie:    cookieOptions{name: kivik.SessionCookieName, path: "/"},
+	}
+}
+
+func TestParseOAuthPath(t *testing.T) {
+	type parseOAuthPathTest struct {
+		name             string
+		path             string
+		provider, action string
+		ok               bool
+	}
+	tests := []parseOAuthPathTest{
+		{name: "Login", path: "/_oauth/google/login", provider: "google", action: "login", ok: true},
+		{name: "Callback", path: "/_oauth/google/callback", provider: "google", action: "callback", ok: true},
+		{name: "NoPrefix", path: "/_session"},
+		{name: "NoAction", path: "/_oauth/google"},
+		{name: "NoProvider", path: "/_oauth//login"},
+		{name: "UnknownAction", path: "/_oauth/google/logout"},
+	}
+	for _, test := range tests {
+		func(test parseOAuthPathTest) {
+			t.Run(test.name, func(t *testing.T) {
+				provider, action, ok := parseOAuthPath(test.path)
+				if ok != test.ok || provider != test.provider || action != test.action {
+					t.Errorf("parseOAuthPath(%q) = %q, %q, %t; want %q, %q, %t",
+						test.path, provider, action, ok, test.provider, test.action, test.ok)
+				}
+			})
+		}(test)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B example
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const expected = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := codeChallenge(verifier); got != expected {
+		t.Errorf("codeChallenge() = %q, want %q", got, expected)
+	}
+}
+
+func TestSignVerifyState(t *testing.T) {
+	m := &mux{secret: "sssh"}
+	st := oauthState{Provider: "google", State: "nonce", CodeVerifier: "verifier", Next: "/foo"}
+	cookie, err := m.signState(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.verifyState(cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != st {
+		t.Errorf("verifyState() = %+v, want %+v", *got, st)
+	}
+
+	if _, err := m.verifyState(cookie.Value + "tampered"); err == nil {
+		t.Error("expected error for tampered state")
+	}
+	other := &mux{secret: "different"}
+	if _, err := other.verifyState(cookie.Value); err == nil {
+		t.Error("expected error verifying with a different secret")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	provider := &fakeOAuth2Provider{
+		authURL:  "https://example.com/auth",
+		clientID: "client123",
+		scopes:   []string{"profile", "email"},
+	}
+	m := newTestMux(map[string]Provider{"example": provider}, "sssh")
+	req := httptest.NewRequest(http.MethodGet, "/_oauth/example/login?next=%2Ffoo", nil)
+	w := httptest.NewRecorder()
+	m.middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+
+	res := w.Result()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("unexpected status: %d", res.StatusCode)
+	}
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	if q.Get("client_id") != "client123" {
+		t.Errorf("unexpected client_id: %s", q.Get("client_id"))
+	}
+	if q.Get("code_challenge_method") != "S256" {
+		t.Errorf("unexpected code_challenge_method: %s", q.Get("code_challenge_method"))
+	}
+	if q.Get("scope") != "profile email" {
+		t.Errorf("unexpected scope: %s", q.Get("scope"))
+	}
+	if len(res.Cookies()) != 1 || res.Cookies()[0].Name != oauthStateCookieName {
+		t.Errorf("expected a single %s cookie, got %v", oauthStateCookieName, res.Cookies())
+	}
+}
+
+func TestCallback(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.Form.Get("code") != "authcode" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"thetoken"}`)
+	}))
+	defer tokenServer.Close()
+
+	provider := &fakeOAuth2Provider{
+		tokenURL: tokenServer.URL,
+		token:    "thetoken",
+		user:     &fb.User{Name: "bob", Salt: "somesalt"},
+	}
+	m := newTestMux(map[string]Provider{"example": provider}, "sssh")
+
+	cookie, err := m.signState(oauthState{Provider: "example", State: "nonce", CodeVerifier: "verifier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/_oauth/example/callback?state=nonce&code=authcode", nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	m.middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", res.StatusCode)
+	}
+}
+
+func TestCallbackStateMismatch(t *testing.T) {
+	provider := &fakeOAuth2Provider{}
+	m := newTestMux(map[string]Provider{"example": provider}, "sssh")
+	cookie, err := m.signState(oauthState{Provider: "example", State: "nonce", CodeVerifier: "verifier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/_oauth/example/callback?state=wrong&code=authcode", nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	m.middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+
+	if res := w.Result(); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,36 @@
+package oauth2
+
+import (
+	"context"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// Provider is a copy of flashback-server2/providers.Provider
+type Provider interface {
+	GetUser(ctx context.Context, token string) (*fb.User, error)
+}
+
+// OAuth2Provider is a Provider that also supports the server-side
+// Authorization Code + PKCE flow driven by the /_oauth/{provider}/login and
+// /_oauth/{provider}/callback endpoints. Providers that only ever receive an
+// access token obtained elsewhere (the /_session path) need not implement
+// this.
+type OAuth2Provider interface {
+	Provider
+
+	// AuthURL returns the provider's authorization endpoint, to which the
+	// user agent is redirected to begin the flow.
+	AuthURL() string
+	// TokenURL returns the provider's token endpoint, used to exchange an
+	// authorization code for an access token.
+	TokenURL() string
+	// ClientID returns the OAuth2 client ID registered with the provider.
+	ClientID() string
+	// ClientSecret returns the OAuth2 client secret registered with the
+	// provider.
+	ClientSecret() string
+	// Scopes returns the scopes to request during authorization. May be
+	// nil if the provider requires none beyond its defaults.
+	Scopes() []string
+}
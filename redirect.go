@@ -0,0 +1,46 @@
+package oauth2
+
+import (
+	"net/url"
+	"strings"
+)
+
+// RedirectValidator reports whether next — the value of a ?next= query
+// parameter — is safe to send the client to after a successful session
+// exchange.
+type RedirectValidator func(next string) bool
+
+// defaultRedirectValidator returns a RedirectValidator that accepts only
+// same-origin relative paths, plus absolute URLs whose host is in
+// allowedHosts.
+func defaultRedirectValidator(allowedHosts []string) RedirectValidator {
+	allowed := make(map[string]bool, len(allowedHosts))
+	for _, h := range allowedHosts {
+		allowed[h] = true
+	}
+	return func(next string) bool {
+		if next == "" {
+			return false
+		}
+		u, err := url.Parse(next)
+		if err != nil {
+			return false
+		}
+		if u.Host != "" {
+			// An absolute URL (or a protocol-relative one) is only safe
+			// when its host is explicitly allowlisted.
+			return allowed[u.Host]
+		}
+		if u.Scheme != "" {
+			return false
+		}
+		// A path of "//evil.com" parses with Host set above, but
+		// "/\evil.com" does not: some browsers treat a backslash as
+		// equivalent to a forward slash, turning it into the
+		// protocol-relative form after the fact. Reject both spellings.
+		if strings.HasPrefix(u.Path, "//") || strings.HasPrefix(u.Path, `/\`) {
+			return false
+		}
+		return true
+	}
+}
@@ -0,0 +1,39 @@
+package oauth2
+
+import "testing"
+
+func TestDefaultRedirectValidator(t *testing.T) {
+	type validatorTest struct {
+		name         string
+		allowedHosts []string
+		next         string
+		want         bool
+	}
+	tests := []validatorTest{
+		{name: "Empty", next: "", want: false},
+		{name: "RelativePath", next: "/oink", want: true},
+		{name: "RelativePathWithQuery", next: "/oink?a=b", want: true},
+		{name: "ProtocolRelative", next: "//evil.com", want: false},
+		{name: "ProtocolRelativeWithPath", next: "//evil.com/x", want: false},
+		{name: "BackslashProtocolRelative", next: `/\evil.com`, want: false},
+		{name: "JavascriptScheme", next: "javascript:alert(1)", want: false},
+		{name: "HTTPSchemeSingleSlash", next: "http:/foo", want: false},
+		{name: "AbsoluteURLNotAllowlisted", next: "https://evil.com/oink", want: false},
+		{
+			name:         "AbsoluteURLAllowlisted",
+			allowedHosts: []string{"app.example.com"},
+			next:         "https://app.example.com/oink",
+			want:         true,
+		},
+	}
+	for _, test := range tests {
+		func(test validatorTest) {
+			t.Run(test.name, func(t *testing.T) {
+				validate := defaultRedirectValidator(test.allowedHosts)
+				if got := validate(test.next); got != test.want {
+					t.Errorf("defaultRedirectValidator(%v)(%q) = %v, want %v", test.allowedHosts, test.next, got, test.want)
+				}
+			})
+		}(test)
+	}
+}
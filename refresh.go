@@ -0,0 +1,167 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/flimzy/kivik/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+const (
+	// refreshCookieName is the long-lived, HttpOnly cookie carrying the
+	// opaque refresh token. Its path covers /_session so that DELETE
+	// /_session can see and revoke it.
+	refreshCookieName = "_oauth2_refresh"
+	refreshCookiePath = "/_session"
+
+	defaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// issueRefreshToken generates a new opaque refresh token for user, saves it
+// via the configured RefreshStore, and sets the refresh cookie. It is a
+// no-op when no RefreshStore is configured.
+func (m *mux) issueRefreshToken(ctx context.Context, w http.ResponseWriter, user *fb.User, provider, parentID string) error {
+	if m.refreshStore == nil {
+		return nil
+	}
+	raw, err := randomString(32)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	token := &RefreshToken{
+		Hash:      hashRefreshToken(raw),
+		UserID:    user.Name,
+		Roles:     user.Roles,
+		Salt:      user.Salt,
+		Provider:  provider,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(m.refreshTTL),
+		ParentID:  parentID,
+	}
+	if err := m.refreshStore.Save(ctx, token); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    raw,
+		Path:     refreshCookiePath,
+		Expires:  token.ExpiresAt,
+		HttpOnly: true,
+		Secure:   m.cookie.secure,
+		SameSite: m.cookie.sameSite,
+	})
+	return nil
+}
+
+// refresh handles POST /_session/refresh: it validates the refresh cookie,
+// rotates it, and issues a fresh session exactly as a successful /_session
+// exchange would. Presenting a refresh token a second time after it has
+// already been rotated is treated as a reuse attack, revoking every
+// refresh token issued to that user.
+func (m *mux) refresh(w http.ResponseWriter, r *http.Request) {
+	if m.refreshStore == nil {
+		reportError(w, errors.Status(http.StatusNotFound, "refresh tokens are not configured"))
+		return
+	}
+	cookie, err := r.Cookie(refreshCookieName)
+	if err != nil {
+		reportError(w, errors.Status(http.StatusUnauthorized, "missing refresh token"))
+		return
+	}
+	newRaw, err := randomString(32)
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	newHash := hashRefreshToken(newRaw)
+	old, err := m.refreshStore.Rotate(r.Context(), hashRefreshToken(cookie.Value), newHash)
+	if err != nil {
+		http.SetCookie(w, expiredRefreshCookie())
+		reportError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	next := &RefreshToken{
+		Hash:      newHash,
+		UserID:    old.UserID,
+		Roles:     old.Roles,
+		Salt:      old.Salt,
+		Provider:  old.Provider,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(m.refreshTTL),
+		ParentID:  old.Hash,
+	}
+	if err := m.refreshStore.Save(r.Context(), next); err != nil {
+		reportError(w, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    newRaw,
+		Path:     refreshCookiePath,
+		Expires:  next.ExpiresAt,
+		HttpOnly: true,
+		Secure:   m.cookie.secure,
+		SameSite: m.cookie.sameSite,
+	})
+	// A refresh can't re-run the provider's token exchange, so the rotated
+	// session is reconstructed from what the RefreshStore tracked at
+	// issuance: user ID, roles, and salt (CouchDBTokenIssuer requires a
+	// non-empty salt to mint a token). Anything else on fb.User (set by
+	// the original login) does not survive a refresh.
+	m.writeSession(w, r, &fb.User{Name: old.UserID, Roles: old.Roles, Salt: old.Salt})
+}
+
+// logout handles DELETE /_session: it clears the session and refresh
+// cookies and revokes the refresh token, if any.
+func (m *mux) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, m.expiredSessionCookie())
+	http.SetCookie(w, expiredRefreshCookie())
+	if m.refreshStore != nil {
+		if cookie, err := r.Cookie(refreshCookieName); err == nil {
+			if err := m.refreshStore.Revoke(r.Context(), hashRefreshToken(cookie.Value)); err != nil {
+				reportError(w, err)
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"ok": true}); err != nil {
+		reportError(w, err)
+	}
+}
+
+func (m *mux) expiredSessionCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     m.cookie.name,
+		Path:     m.cookie.path,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   m.cookie.secure,
+		SameSite: m.cookie.sameSite,
+	}
+}
+
+func expiredRefreshCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     refreshCookieName,
+		Path:     refreshCookiePath,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+	}
+}
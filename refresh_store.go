@@ -0,0 +1,52 @@
+package oauth2
+
+import (
+	"context"
+	"time"
+)
+
+// RefreshToken is a single refresh token record tracked by a RefreshStore.
+// Hash holds sha256(token) base64url-encoded; the raw token itself is never
+// persisted. Roles and Salt are carried forward across rotation so a
+// refreshed session retains the same authorization, and can still be
+// reissued by a TokenIssuer (such as CouchDBTokenIssuer) that requires a
+// salt, as the one it replaces.
+type RefreshToken struct {
+	Hash      string
+	UserID    string
+	Roles     []string
+	Salt      string
+	Provider  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	ParentID  string
+
+	// Used and ReplacedBy are set by Rotate once a token has been
+	// exchanged for its successor, so a second presentation of the same
+	// token can be recognized as reuse.
+	Used       bool
+	ReplacedBy string
+}
+
+// RefreshStore persists refresh tokens and detects reuse of a token that
+// has already been rotated. Implementations must be safe for concurrent
+// use. MemoryRefreshStore is suitable for tests; production deployments
+// should back this with CouchDB or Redis.
+type RefreshStore interface {
+	// Save stores a freshly issued refresh token.
+	Save(ctx context.Context, token *RefreshToken) error
+
+	// Rotate looks up the token by hash and marks it used, recording
+	// replacedBy as its successor's hash. It returns the token record as it
+	// was *before* rotation. If the token was already marked used,
+	// implementations must treat this as a reuse attack: revoke every
+	// token issued to the same user and return an error.
+	Rotate(ctx context.Context, hash, replacedBy string) (*RefreshToken, error)
+
+	// Revoke invalidates a single refresh token by hash.
+	Revoke(ctx context.Context, hash string) error
+
+	// RevokeAllForUser invalidates every refresh token issued to userID,
+	// e.g. in response to a detected reuse or an explicit logout-everywhere.
+	RevokeAllForUser(ctx context.Context, userID string) error
+}
@@ -0,0 +1,89 @@
+package oauth2
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryRefreshStoreRotate(t *testing.T) {
+	store := NewMemoryRefreshStore()
+	ctx := context.Background()
+	orig := &RefreshToken{
+		Hash:      "h1",
+		UserID:    "alice",
+		Provider:  "example",
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, orig); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Rotate(ctx, "h1", "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != "alice" || got.Provider != "example" {
+		t.Errorf("unexpected token: %+v", got)
+	}
+
+	// A second rotation of the same (now-used) token is reuse.
+	if _, err := store.Rotate(ctx, "h1", "h3"); err == nil {
+		t.Error("expected an error rotating an already-used token")
+	}
+}
+
+func TestMemoryRefreshStoreRotateUnknown(t *testing.T) {
+	store := NewMemoryRefreshStore()
+	if _, err := store.Rotate(context.Background(), "nope", "h2"); err == nil {
+		t.Error("expected an error rotating an unknown token")
+	}
+}
+
+func TestMemoryRefreshStoreRotateExpired(t *testing.T) {
+	store := NewMemoryRefreshStore()
+	ctx := context.Background()
+	if err := store.Save(ctx, &RefreshToken{Hash: "h1", UserID: "alice", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Rotate(ctx, "h1", "h2"); err == nil {
+		t.Error("expected an error rotating an expired token")
+	}
+}
+
+func TestMemoryRefreshStoreReuseRevokesChain(t *testing.T) {
+	store := NewMemoryRefreshStore()
+	ctx := context.Background()
+	if err := store.Save(ctx, &RefreshToken{Hash: "h1", UserID: "alice", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(ctx, &RefreshToken{Hash: "h2", UserID: "alice", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Rotate(ctx, "h1", "h1b"); err != nil {
+		t.Fatal(err)
+	}
+	// Presenting h1 again (already used) must revoke every token for alice,
+	// including the unrelated h2.
+	if _, err := store.Rotate(ctx, "h1", "h1c"); err == nil {
+		t.Fatal("expected reuse error")
+	}
+	if _, err := store.Rotate(ctx, "h2", "h2b"); err == nil {
+		t.Error("expected h2 to have been revoked as part of the reuse response")
+	}
+}
+
+func TestMemoryRefreshStoreRevoke(t *testing.T) {
+	store := NewMemoryRefreshStore()
+	ctx := context.Background()
+	if err := store.Save(ctx, &RefreshToken{Hash: "h1", UserID: "alice", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(ctx, "h1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Rotate(ctx, "h1", "h2"); err == nil {
+		t.Error("expected rotate of a revoked token to fail")
+	}
+}
@@ -0,0 +1,141 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/flimzy/kivik"
+	"github.com/flimzy/kivik/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// session handles POST /_session: a client presents exactly one grant
+// (access_token, id_token, code, or refresh_token) and, if the named
+// provider supports it, is issued a session.
+func (m *mux) session(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	authReq, err := parseAuthRequest(r)
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	if authReq == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+	provider, ok := m.providers[authReq.Provider]
+	if !ok {
+		reportError(w, errors.Statusf(http.StatusBadRequest, "unknown auth provider `%s`", authReq.Provider))
+		return
+	}
+	user, err := m.authenticate(r.Context(), provider, authReq)
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	m.issueSession(w, r, user, authReq.Provider)
+}
+
+// authenticate dispatches authReq's grant to the capability the named
+// provider needs to implement to honor it, returning a descriptive 400 if
+// it doesn't.
+func (m *mux) authenticate(ctx context.Context, provider Provider, authReq *authRequest) (*fb.User, error) {
+	switch {
+	case authReq.Token != "":
+		return provider.GetUser(ctx, authReq.Token)
+
+	case authReq.IDToken != "":
+		idp, ok := provider.(IDTokenProvider)
+		if !ok {
+			return nil, errors.Statusf(http.StatusBadRequest, "provider `%s` does not support id_token verification", authReq.Provider)
+		}
+		return idp.VerifyIDToken(ctx, authReq.IDToken)
+
+	case authReq.Code != "":
+		cep, ok := provider.(CodeExchangeProvider)
+		if !ok {
+			return nil, errors.Statusf(http.StatusBadRequest, "provider `%s` does not support authorization code exchange", authReq.Provider)
+		}
+		grant := url.Values{"code": {authReq.Code}, "redirect_uri": {authReq.RedirectURI}}
+		if authReq.CodeVerifier != "" {
+			grant.Set("code_verifier", authReq.CodeVerifier)
+		}
+		token, err := exchangeToken(ctx, m.client, cep, "authorization_code", grant)
+		if err != nil {
+			return nil, err
+		}
+		return provider.GetUser(ctx, token)
+
+	case authReq.RefreshToken != "":
+		cep, ok := provider.(CodeExchangeProvider)
+		if !ok {
+			return nil, errors.Statusf(http.StatusBadRequest, "provider `%s` does not support refresh_token exchange", authReq.Provider)
+		}
+		token, err := exchangeToken(ctx, m.client, cep, "refresh_token", url.Values{"refresh_token": {authReq.RefreshToken}})
+		if err != nil {
+			return nil, err
+		}
+		return provider.GetUser(ctx, token)
+
+	default:
+		return nil, errors.Status(http.StatusBadRequest, "no auth grant provided")
+	}
+}
+
+// issueSession issues a refresh token (if a RefreshStore is configured) and
+// then writes the session. It is shared by the direct access-token exchange
+// and the Authorization Code callback; the refresh endpoint rotates its own
+// refresh token and calls writeSession directly instead.
+func (m *mux) issueSession(w http.ResponseWriter, r *http.Request, user *fb.User, provider string) {
+	if err := m.issueRefreshToken(r.Context(), w, user, provider, ""); err != nil {
+		reportError(w, err)
+		return
+	}
+	m.writeSession(w, r, user)
+}
+
+// writeSession mints a session token via the configured TokenIssuer, sets
+// it as the session cookie for user, and writes the standard JSON response,
+// honoring an optional ?next= redirect.
+func (m *mux) writeSession(w http.ResponseWriter, r *http.Request, user *fb.User) {
+	value, expiresAt, err := m.issuer.Issue(r.Context(), user)
+	if err != nil {
+		reportError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "must-revalidate")
+	w.Header().Add("Content-Type", "application/json")
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookie.name,
+		Value:    value,
+		Path:     m.cookie.path,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+		HttpOnly: true,
+		Secure:   m.cookie.secure,
+		SameSite: m.cookie.sameSite,
+	})
+	if redir := r.URL.Query().Get("next"); redir != "" {
+		if !m.redirectValidator(redir) {
+			reportError(w, errors.Status(http.StatusBadRequest, "prohibited redirection"))
+			return
+		}
+		w.Header().Add("Location", redir)
+		w.WriteHeader(kivik.StatusFound)
+	} else {
+		w.WriteHeader(kivik.StatusOK)
+	}
+	body := map[string]interface{}{
+		"ok":    true,
+		"name":  user.Name,
+		"roles": user.Roles,
+	}
+	if _, isJWT := m.issuer.(*JWTTokenIssuer); isJWT {
+		body["token"] = value
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		reportError(w, err)
+	}
+}
@@ -0,0 +1,160 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestRefreshFlow(t *testing.T) {
+	provider := &fakeOAuth2Provider{token: "tok", user: &fb.User{Name: "bob", Salt: "somesalt"}}
+	mw := OAuth2(map[string]Provider{"testprovider": provider}, Options{
+		Secret:       "foo",
+		RefreshStore: NewMemoryRefreshStore(),
+	})
+	res := doLogin(t, mw)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("login: unexpected status: %d", res.StatusCode)
+	}
+	var refreshCookie *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == refreshCookieName {
+			refreshCookie = c
+		}
+	}
+	if refreshCookie == nil {
+		t.Fatal("expected a refresh cookie")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/_session/refresh", nil)
+	req.AddCookie(refreshCookie)
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+	res = w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("refresh: unexpected status: %d", res.StatusCode)
+	}
+	var rotated *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == refreshCookieName {
+			rotated = c
+		}
+	}
+	if rotated == nil || rotated.Value == refreshCookie.Value {
+		t.Fatal("expected a newly rotated refresh cookie")
+	}
+
+	// Reusing the original (now-rotated) refresh token must fail.
+	reuseReq := httptest.NewRequest(http.MethodPost, "/_session/refresh", nil)
+	reuseReq.AddCookie(refreshCookie)
+	w = httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, reuseReq)
+	if res := w.Result(); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected reuse to be rejected, got status %d", res.StatusCode)
+	}
+}
+
+func TestRefreshPreservesRoles(t *testing.T) {
+	provider := &fakeOAuth2Provider{token: "tok", user: &fb.User{Name: "bob", Roles: []string{"admin"}, Salt: "somesalt"}}
+	mw := OAuth2(map[string]Provider{"testprovider": provider}, Options{
+		Secret:       "foo",
+		RefreshStore: NewMemoryRefreshStore(),
+	})
+	res := doLogin(t, mw)
+	var refreshCookie *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == refreshCookieName {
+			refreshCookie = c
+		}
+	}
+	if refreshCookie == nil {
+		t.Fatal("expected a refresh cookie")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/_session/refresh", nil)
+	req.AddCookie(refreshCookie)
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+	res = w.Result()
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("refresh: unexpected status: %d", res.StatusCode)
+	}
+	var body struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(body.Roles, []string{"admin"}) {
+		t.Errorf("expected roles to survive the refresh, got %v", body.Roles)
+	}
+}
+
+func TestRefreshWithoutStore(t *testing.T) {
+	mw := OAuth2(nil, Options{Secret: "foo"})
+	req := httptest.NewRequest(http.MethodPost, "/_session/refresh", nil)
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+	if res := w.Result(); res.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status: %d", res.StatusCode)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	provider := &fakeOAuth2Provider{token: "tok", user: &fb.User{Name: "bob", Salt: "somesalt"}}
+	store := NewMemoryRefreshStore()
+	mw := OAuth2(map[string]Provider{"testprovider": provider}, Options{
+		Secret:       "foo",
+		RefreshStore: store,
+	})
+	res := doLogin(t, mw)
+	var refreshCookie *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == refreshCookieName {
+			refreshCookie = c
+		}
+	}
+	if refreshCookie == nil {
+		t.Fatal("expected a refresh cookie")
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/_session", nil)
+	req.AddCookie(refreshCookie)
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, req)
+	res = w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", res.StatusCode)
+	}
+	for _, c := range res.Cookies() {
+		if c.MaxAge >= 0 {
+			t.Errorf("expected cookie %s to be expired, got MaxAge=%d", c.Name, c.MaxAge)
+		}
+	}
+
+	// The revoked refresh token must no longer be usable.
+	refreshReq := httptest.NewRequest(http.MethodPost, "/_session/refresh", nil)
+	refreshReq.AddCookie(refreshCookie)
+	w = httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})).ServeHTTP(w, refreshReq)
+	if res := w.Result(); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected revoked refresh token to be rejected, got %d", res.StatusCode)
+	}
+}